@@ -0,0 +1,137 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// Package astjson converts a secl rule AST to a stable, schema-versioned
+// JSON tree suitable for consumption by external editors or diffing
+// tools. The shape of the tree (node ids, children) comes from
+// astwalk.Default, the same as dot.Marshaler and mermaid.Marshaler.
+package astjson
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/DataDog/datadog-agent/pkg/security/secl/ast"
+	"github.com/DataDog/datadog-agent/pkg/security/secl/astwalk"
+	"github.com/DataDog/datadog-agent/pkg/security/secl/eval"
+)
+
+// SchemaVersion is bumped whenever the shape of Node changes in a way
+// that isn't backward compatible for consumers of MarshalRule's output.
+const SchemaVersion = 1
+
+// Node is one node of the marshaled tree: its id and kind (the ast.*
+// type name, or a synthetic leaf kind such as "Op" or "Ident"), its
+// source offset, its payload for leaf kinds, and its children.
+type Node struct {
+	ID       string      `json:"id"`
+	Kind     string      `json:"kind"`
+	Offset   int         `json:"offset,omitempty"`
+	Payload  interface{} `json:"payload,omitempty"`
+	Children []*Node     `json:"children,omitempty"`
+	Trace    *NodeTrace  `json:"trace,omitempty"`
+}
+
+// NodeTrace is the optional runtime annotation attached to a Node by
+// MarshalRuleWithTrace: what the node evaluated to, and, for comparison
+// nodes, the concrete operand values that were compared.
+type NodeTrace struct {
+	Result string      `json:"result"`
+	Left   interface{} `json:"left,omitempty"`
+	Right  interface{} `json:"right,omitempty"`
+}
+
+// Document is the top-level value written by Marshaler.MarshalRule.
+type Document struct {
+	SchemaVersion int   `json:"schema_version"`
+	Root          *Node `json:"root"`
+}
+
+// Marshaler converts a rule to the Document format above.
+type Marshaler struct {
+	w io.Writer
+}
+
+// NewMarshaler returns a new rule JSON marshaler
+func NewMarshaler(w io.Writer) *Marshaler {
+	return &Marshaler{w: w}
+}
+
+// MarshalRule marshals the AST of a rule to JSON
+func (j *Marshaler) MarshalRule(r *ast.Rule) error {
+	return j.marshalRule(r, nil)
+}
+
+// MarshalRuleWithTrace marshals the AST of a rule to JSON, attaching a
+// NodeTrace to every node trace has a record for. See
+// dot.Marshaler.MarshalRuleWithTrace for what gets recorded.
+func (j *Marshaler) MarshalRuleWithTrace(r *ast.Rule, trace *eval.Trace) error {
+	return j.marshalRule(r, trace)
+}
+
+func (j *Marshaler) marshalRule(r *ast.Rule, trace *eval.Trace) error {
+	root, err := buildNode(r.BooleanExpression, trace)
+	if err != nil {
+		return err
+	}
+
+	doc := &Document{
+		SchemaVersion: SchemaVersion,
+		Root:          root,
+	}
+
+	return json.NewEncoder(j.w).Encode(doc)
+}
+
+// buildNode recurses through n using astwalk.Default, the same
+// structural description the DOT and Mermaid marshalers use, but builds
+// a nested tree rather than emitting edges as it goes: JSON, unlike DOT
+// and Mermaid, needs the whole subtree before it can close a node.
+func buildNode(n interface{}, trace *eval.Trace) (*Node, error) {
+	info, err := astwalk.Visit(astwalk.Default, n)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &Node{
+		ID:      info.ID,
+		Kind:    info.Kind,
+		Offset:  info.Offset,
+		Payload: info.Payload,
+	}
+
+	// Synthetic leaf nodes (Op, Ident, Number, String, ...) aren't
+	// Traceable: they don't correspond to a single ast.* node with its
+	// own offset, so looking them up in trace would misattribute
+	// whatever trace recorded at offset 0 to every leaf in the rule.
+	if info.Traceable {
+		if nt, ok := trace.Lookup(info.Offset); ok {
+			node.Trace = &NodeTrace{Result: traceResultName(nt.Result), Left: nt.Left, Right: nt.Right}
+		}
+	}
+
+	for _, child := range info.Children {
+		childNode, err := buildNode(child, trace)
+		if err != nil {
+			return nil, err
+		}
+		node.Children = append(node.Children, childNode)
+	}
+
+	return node, nil
+}
+
+func traceResultName(result eval.Result) string {
+	switch result {
+	case eval.True:
+		return "true"
+	case eval.False:
+		return "false"
+	case eval.ShortCircuited:
+		return "short_circuited"
+	default:
+		return "unknown"
+	}
+}
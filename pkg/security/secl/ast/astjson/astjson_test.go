@@ -0,0 +1,67 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+package astjson
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/security/secl/ast/asttest"
+	"github.com/DataDog/datadog-agent/pkg/security/secl/eval"
+)
+
+func TestMarshalRule(t *testing.T) {
+	rule := asttest.IdentEqualsString()
+
+	var buf bytes.Buffer
+	if err := NewMarshaler(&buf).MarshalRule(rule); err != nil {
+		t.Fatalf("MarshalRule: %v", err)
+	}
+
+	var doc Document
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("unmarshal output: %v", err)
+	}
+
+	if doc.SchemaVersion != SchemaVersion {
+		t.Fatalf("got schema version %d, want %d", doc.SchemaVersion, SchemaVersion)
+	}
+	if doc.Root == nil || doc.Root.Kind != "BooleanExpression" {
+		t.Fatalf("got root %+v, want a BooleanExpression root", doc.Root)
+	}
+}
+
+func TestMarshalRuleWithTrace(t *testing.T) {
+	rule := asttest.TracedIdentEqualsString()
+
+	trace := eval.NewTrace()
+	trace.RecordComparison(42, eval.False, "a", "x")
+
+	var buf bytes.Buffer
+	if err := NewMarshaler(&buf).MarshalRuleWithTrace(rule, trace); err != nil {
+		t.Fatalf("MarshalRuleWithTrace: %v", err)
+	}
+
+	var doc Document
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("unmarshal output: %v", err)
+	}
+
+	comparisonNode := doc.Root.Children[0].Children[0]
+	if comparisonNode.Kind != "Comparison" {
+		t.Fatalf("got kind %q, want Comparison", comparisonNode.Kind)
+	}
+	if comparisonNode.Trace == nil {
+		t.Fatalf("expected the traced comparison node to carry a trace annotation")
+	}
+	if comparisonNode.Trace.Result != "false" {
+		t.Fatalf("got trace result %q, want false", comparisonNode.Trace.Result)
+	}
+	if comparisonNode.Trace.Left != "a" || comparisonNode.Trace.Right != "x" {
+		t.Fatalf("got trace operands %v/%v, want a/x", comparisonNode.Trace.Left, comparisonNode.Trace.Right)
+	}
+}
@@ -0,0 +1,75 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// Package asttest builds small *ast.Rule fixtures shared by the tests of
+// astwalk and its marshalers (dot, mermaid, astjson), so each of them
+// doesn't hand-build its own copy of the same AST.
+package asttest
+
+import "github.com/DataDog/datadog-agent/pkg/security/secl/ast"
+
+// IdentEqualsString builds the AST for `a == "x"`: a single BitOperation
+// wrapping an Ident, compared by a ScalarComparison against a String
+// literal.
+func IdentEqualsString() *ast.Rule {
+	ident := "a"
+	op := "=="
+	str := "x"
+
+	return &ast.Rule{
+		BooleanExpression: &ast.BooleanExpression{
+			Expression: &ast.Expression{
+				Comparison: &ast.Comparison{
+					BitOperation: &ast.BitOperation{
+						Unary: &ast.Unary{
+							Primary: &ast.Primary{Ident: &ident},
+						},
+					},
+					ScalarComparison: &ast.ScalarComparison{
+						Op: &op,
+						Next: &ast.BitOperation{
+							Unary: &ast.Unary{
+								Primary: &ast.Primary{String: &str},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// TracedIdentEqualsString builds the same `a == "x"` rule as
+// IdentEqualsString, except its Comparison carries a real source offset
+// (42) so it can be looked up in an eval.Trace - the fixture every
+// MarshalRuleWithTrace test across the marshalers needs.
+func TracedIdentEqualsString() *ast.Rule {
+	ident := "a"
+	op := "=="
+	str := "x"
+
+	comparison := &ast.Comparison{
+		Pos: ast.Pos{Offset: 42},
+		BitOperation: &ast.BitOperation{
+			Unary: &ast.Unary{
+				Primary: &ast.Primary{Ident: &ident},
+			},
+		},
+		ScalarComparison: &ast.ScalarComparison{
+			Op: &op,
+			Next: &ast.BitOperation{
+				Unary: &ast.Unary{
+					Primary: &ast.Primary{String: &str},
+				},
+			},
+		},
+	}
+
+	return &ast.Rule{
+		BooleanExpression: &ast.BooleanExpression{
+			Expression: &ast.Expression{Comparison: comparison},
+		},
+	}
+}
@@ -8,53 +8,69 @@ package dot
 import (
 	"fmt"
 	"io"
-	"reflect"
 	"strconv"
 	"strings"
 
 	"github.com/DataDog/datadog-agent/pkg/security/secl/ast"
+	"github.com/DataDog/datadog-agent/pkg/security/secl/astwalk"
+	"github.com/DataDog/datadog-agent/pkg/security/secl/eval"
 )
 
-type node struct {
-	id    string
-	label string
+// Marshaler converts a rule to the DOT format. The shape of the tree
+// (node ids, children) comes from astwalk.Default; this type only knows
+// how to render it as DOT.
+type Marshaler struct {
+	w io.Writer
 }
 
-func newNode(id, label string) *node {
-	return &node{id: id, label: label}
+// NewMarshaler returns a new rule DOT marshaler
+func NewMarshaler(w io.Writer) *Marshaler {
+	return &Marshaler{w: w}
 }
 
-// Marshaler converts a rule to the DOT format
-type Marshaler struct {
-	w io.Writer
+// MarshalRule marshals the AST of a rule to DOT format
+func (d *Marshaler) MarshalRule(r *ast.Rule) error {
+	return d.marshalRule(r, nil)
 }
 
-func (d *Marshaler) writeString(s string) error {
-	_, err := io.WriteString(d.w, s)
-	return err
+// MarshalRuleWithTrace marshals the AST of a rule to DOT format, coloring
+// each node according to what trace recorded when the rule was evaluated
+// against a concrete event: green if the node evaluated true, red if
+// false, grey if it was short-circuited, and annotated with the
+// concrete left/right values for comparison nodes that were evaluated.
+// Nodes trace has no record for (e.g. because the event never reached
+// them) are rendered exactly as MarshalRule would render them.
+func (d *Marshaler) MarshalRuleWithTrace(r *ast.Rule, trace *eval.Trace) error {
+	return d.marshalRule(r, trace)
 }
 
-func (d *Marshaler) writeNode(node interface{}) error {
-	id, err := d.getID(node)
-	if err != nil {
+func (d *Marshaler) marshalRule(r *ast.Rule, trace *eval.Trace) error {
+	if err := d.writeString("digraph {\n"); err != nil {
 		return err
 	}
-
-	if err := d.writeString(id + "[label=\"" + d.getLabel(node) + "\"]\n"); err != nil {
+	fn := func(n interface{}, info astwalk.Info) error { return d.writeNode(info, trace) }
+	if err := astwalk.Walk(astwalk.Default, r.BooleanExpression, fn); err != nil {
 		return err
 	}
+	return d.writeString("}\n")
+}
 
-	children, err := d.getChildren(node)
-	if err != nil {
+func (d *Marshaler) writeString(s string) error {
+	_, err := io.WriteString(d.w, s)
+	return err
+}
+
+func (d *Marshaler) writeNode(info astwalk.Info, trace *eval.Trace) error {
+	if err := d.writeString(info.ID + "[" + attrs(info, trace) + "]\n"); err != nil {
 		return err
 	}
 
-	for _, child := range children {
-		if err := d.writeEdge(node, child); err != nil {
+	for _, child := range info.Children {
+		childInfo, err := astwalk.Visit(astwalk.Default, child)
+		if err != nil {
 			return err
 		}
-
-		if err := d.writeNode(child); err != nil {
+		if err := d.writeString(info.ID + " -> " + childInfo.ID + "\n"); err != nil {
 			return err
 		}
 	}
@@ -62,164 +78,64 @@ func (d *Marshaler) writeNode(node interface{}) error {
 	return nil
 }
 
-func (d *Marshaler) writeEdge(parent, child interface{}) error {
-	parentID, err := d.getID(parent)
-	if err != nil {
-		return err
+// attrs renders a node's DOT attribute list: always a label, plus a
+// fill color and operand annotation when trace recorded a result for
+// this node's offset. Synthetic leaf nodes (Op, Ident, Number, String,
+// ...) aren't Traceable: they don't correspond to a single ast.* node
+// with its own offset, so looking them up in trace would misattribute
+// whatever trace recorded at offset 0 to every leaf in the rule.
+func attrs(info astwalk.Info, trace *eval.Trace) string {
+	if !info.Traceable {
+		return "label=\"" + label(info) + "\""
 	}
 
-	childID, err := d.getID(child)
-	if err != nil {
-		return err
+	nt, ok := trace.Lookup(info.Offset)
+	if !ok {
+		return "label=\"" + label(info) + "\""
 	}
 
-	return d.writeString(parentID + " -> " + childID + "\n")
-}
-
-func (d *Marshaler) getID(n interface{}) (string, error) {
-	switch n := n.(type) {
-	case *ast.Rule:
-		return fmt.Sprintf("Rule%d", n.Pos.Offset), nil
-	case *ast.Expression:
-		return fmt.Sprintf("Expression%d", n.Pos.Offset), nil
-	case *ast.Comparison:
-		return fmt.Sprintf("Comparison%d", n.Pos.Offset), nil
-	case *ast.ScalarComparison:
-		return fmt.Sprintf("ScalarComparison%d", n.Pos.Offset), nil
-	case *ast.ArrayComparison:
-		return fmt.Sprintf("ArrayComparison%d", n.Pos.Offset), nil
-	case *ast.Array:
-		return fmt.Sprintf("Array%d", n.Pos.Offset), nil
-	case *ast.BooleanExpression:
-		return fmt.Sprintf("BooleanExpression%d", n.Pos.Offset), nil
-	case *ast.BitOperation:
-		return fmt.Sprintf("BitOperation%d", n.Pos.Offset), nil
-	case *ast.Unary:
-		return fmt.Sprintf("Unary%d", n.Pos.Offset), nil
-	case *ast.Primary:
-		return fmt.Sprintf("Primary%d", n.Pos.Offset), nil
-	case *node:
-		return n.id, nil
-	default:
-		return "", fmt.Errorf("unsupported node type: %s", reflect.TypeOf(n))
+	l := label(info)
+	if nt.Left != nil || nt.Right != nil {
+		l = fmt.Sprintf("%s\\n%v vs %v", l, nt.Left, nt.Right)
 	}
-}
 
-func (d *Marshaler) getLabel(n interface{}) string {
-	switch n := n.(type) {
-	case *node:
-		return n.label
-	default:
-		split := strings.SplitN(reflect.TypeOf(n).String(), ".", 2)
-		return split[len(split)-1]
-	}
+	return fmt.Sprintf("label=%q style=filled fillcolor=%s", l, traceColor(nt.Result))
 }
 
-func (d *Marshaler) getChildren(n interface{}) ([]interface{}, error) {
-	switch n := n.(type) {
-	case *ast.Rule:
-		return []interface{}{n.BooleanExpression}, nil
-	case *ast.Expression:
-		children := []interface{}{n.Comparison}
-		if n.Op != nil {
-			children = append(children, newNode(fmt.Sprintf("Op%p", n.Op), fmt.Sprintf("Op\\n%s", *n.Op)))
-		}
-		if n.Next != nil {
-			children = append(children, n.Next)
-		}
-		return children, nil
-	case *ast.BooleanExpression:
-		return []interface{}{n.Expression}, nil
-	case *ast.Comparison:
-		children := []interface{}{n.BitOperation}
-		if n.ArrayComparison != nil {
-			children = append(children, n.ArrayComparison)
-		}
-		if n.ScalarComparison != nil {
-			children = append(children, n.ScalarComparison)
-		}
-		return children, nil
-	case *ast.ArrayComparison:
-		return []interface{}{
-			newNode(fmt.Sprintf("Op%p", n.Op), fmt.Sprintf("Op\\n%s", *n.Op)),
-			n.Array,
-		}, nil
-	case *ast.ScalarComparison:
-		return []interface{}{
-			newNode(fmt.Sprintf("Op%p", n.Op), fmt.Sprintf("Op\\n%s", *n.Op)),
-			n.Next,
-		}, nil
-	case *ast.Array:
-		if len(n.Strings) > 0 {
-			return []interface{}{
-				newNode(fmt.Sprintf("Array%p", n), strings.Join(n.Strings, ",")),
-			}, nil
-		}
-		s := ""
-		for i, n := range n.Numbers {
-			if i != 0 {
-				s += ", " + strconv.Itoa(n)
-			} else {
-				s += strconv.Itoa(n)
-			}
-		}
-		return []interface{}{
-			newNode(fmt.Sprintf("Array%p", n), s),
-		}, nil
-	case *ast.BitOperation:
-		children := []interface{}{n.Unary}
-		if n.Op != nil {
-			children = append(children, newNode(fmt.Sprintf("Op%p", n.Op), fmt.Sprintf("Op\\n%s", *n.Op)))
-		}
-		if n.Next != nil {
-			children = append(children, n.Next)
-		}
-		return children, nil
-	case *ast.Unary:
-		var children []interface{}
-		if n.Op != nil {
-			children = append(children, newNode(fmt.Sprintf("Op%p", n.Op), fmt.Sprintf("Op\\n%s", *n.Op)))
-		}
-		if n.Unary != nil {
-			children = append(children, n.Unary)
-		}
-		if n.Primary != nil {
-			children = append(children, n.Primary)
-		}
-		return children, nil
-	case *ast.Primary:
-		if n.Ident != nil {
-			return []interface{}{newNode(fmt.Sprintf("Ident%p", n.Ident), fmt.Sprintf("Ident\\n%s", *n.Ident))}, nil
-		}
-		if n.Number != nil {
-			return []interface{}{newNode(fmt.Sprintf("Number%p", n.Number), fmt.Sprintf("Number\\n%d", *n.Number))}, nil
-		}
-		if n.String != nil {
-			return []interface{}{newNode(fmt.Sprintf("String%p", n.String), fmt.Sprintf("String\\n%s", *n.String))}, nil
-		}
-		if n.SubExpression != nil {
-			return []interface{}{n.SubExpression}, nil
-		}
-		return nil, fmt.Errorf("empty ast.Primary")
-	case *node:
-		return nil, nil
+func traceColor(result eval.Result) string {
+	switch result {
+	case eval.True:
+		return "green"
+	case eval.False:
+		return "red"
 	default:
-		return nil, fmt.Errorf("unsupported node type: %s", reflect.TypeOf(n))
+		return "grey"
 	}
 }
 
-// MarshalRule marshals the AST of a rule to DOT format
-func (d *Marshaler) MarshalRule(r *ast.Rule) error {
-	if err := d.writeString("digraph {\n"); err != nil {
-		return err
-	}
-	if err := d.writeNode(r.BooleanExpression); err != nil {
-		return err
+// label renders a node's Info as a DOT label. Container nodes (Rule,
+// Expression, ...) are labeled with their kind; leaf kinds get their
+// payload on a second line.
+func label(info astwalk.Info) string {
+	switch info.Kind {
+	case "Op":
+		return fmt.Sprintf("Op\\n%s", info.Payload)
+	case "Ident":
+		return fmt.Sprintf("Ident\\n%s", info.Payload)
+	case "Number":
+		return fmt.Sprintf("Number\\n%d", info.Payload)
+	case "String":
+		return fmt.Sprintf("String\\n%s", info.Payload)
+	case "StringArray":
+		return fmt.Sprintf("StringArray\\n%s", strings.Join(info.Payload.([]string), ","))
+	case "NumberArray":
+		numbers := info.Payload.([]int)
+		parts := make([]string, len(numbers))
+		for i, n := range numbers {
+			parts[i] = strconv.Itoa(n)
+		}
+		return fmt.Sprintf("NumberArray\\n%s", strings.Join(parts, ", "))
+	default:
+		return info.Kind
 	}
-	return d.writeString("}\n")
-}
-
-// NewMarshaler returns a new rule DOT marshaler
-func NewMarshaler(w io.Writer) *Marshaler {
-	return &Marshaler{w: w}
 }
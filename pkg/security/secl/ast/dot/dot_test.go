@@ -0,0 +1,110 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+package dot
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/security/secl/ast"
+	"github.com/DataDog/datadog-agent/pkg/security/secl/ast/asttest"
+	"github.com/DataDog/datadog-agent/pkg/security/secl/eval"
+)
+
+func TestMarshalRule(t *testing.T) {
+	rule := asttest.IdentEqualsString()
+
+	var sb strings.Builder
+	if err := NewMarshaler(&sb).MarshalRule(rule); err != nil {
+		t.Fatalf("MarshalRule: %v", err)
+	}
+
+	out := sb.String()
+	if !strings.HasPrefix(out, "digraph {\n") || !strings.HasSuffix(out, "}\n") {
+		t.Fatalf("output is not a digraph: %q", out)
+	}
+	if !strings.Contains(out, `label="Ident\na"`) {
+		t.Fatalf("missing Ident leaf in output: %q", out)
+	}
+	if !strings.Contains(out, `label="String\nx"`) {
+		t.Fatalf("missing String leaf in output: %q", out)
+	}
+}
+
+func TestMarshalRuleWithTrace(t *testing.T) {
+	rule := asttest.TracedIdentEqualsString()
+
+	trace := eval.NewTrace()
+	trace.RecordComparison(42, eval.False, "a", "x")
+
+	var sb strings.Builder
+	if err := NewMarshaler(&sb).MarshalRuleWithTrace(rule, trace); err != nil {
+		t.Fatalf("MarshalRuleWithTrace: %v", err)
+	}
+
+	out := sb.String()
+	if !strings.Contains(out, "fillcolor=red") {
+		t.Fatalf("expected the traced comparison node to be colored red: %q", out)
+	}
+	if !strings.Contains(out, "a vs x") {
+		t.Fatalf("expected the traced comparison node to be annotated with its operands: %q", out)
+	}
+}
+
+func TestMarshalRuleWithTraceDoesNotLeakOntoLeaves(t *testing.T) {
+	identA, identB := "a", "b"
+	op := "=="
+	strX, strY := "x", "y"
+	andOp := "&&"
+
+	rule := &ast.Rule{
+		BooleanExpression: &ast.BooleanExpression{
+			Expression: &ast.Expression{
+				Comparison: &ast.Comparison{
+					BitOperation: &ast.BitOperation{
+						Unary: &ast.Unary{Primary: &ast.Primary{Ident: &identA}},
+					},
+					ScalarComparison: &ast.ScalarComparison{
+						Op:   &op,
+						Next: &ast.BitOperation{Unary: &ast.Unary{Primary: &ast.Primary{String: &strX}}},
+					},
+				},
+				Op: &andOp,
+				Next: &ast.Expression{
+					Comparison: &ast.Comparison{
+						BitOperation: &ast.BitOperation{
+							Unary: &ast.Unary{Primary: &ast.Primary{Ident: &identB}},
+						},
+						ScalarComparison: &ast.ScalarComparison{
+							Op:   &op,
+							Next: &ast.BitOperation{Unary: &ast.Unary{Primary: &ast.Primary{String: &strY}}},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	// Only the first comparison was actually evaluated. None of the
+	// leaves below it carry a real source offset (they're synthesized by
+	// astwalk, not parsed), so they must never be colored by this trace
+	// entry no matter what offset it was recorded at.
+	trace := eval.NewTrace()
+	trace.RecordComparison(0, eval.True, "a", "x")
+
+	var sb strings.Builder
+	if err := NewMarshaler(&sb).MarshalRuleWithTrace(rule, trace); err != nil {
+		t.Fatalf("MarshalRuleWithTrace: %v", err)
+	}
+
+	for _, line := range strings.Split(sb.String(), "\n") {
+		if strings.Contains(line, `Ident\nb`) || strings.Contains(line, `String\ny`) {
+			if strings.Contains(line, "style=filled") {
+				t.Fatalf("unrelated leaf was colored by a trace entry it has no connection to: %q", line)
+			}
+		}
+	}
+}
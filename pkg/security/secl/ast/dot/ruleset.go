@@ -0,0 +1,333 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+package dot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/DataDog/datadog-agent/pkg/security/secl/ast"
+	"github.com/DataDog/datadog-agent/pkg/security/secl/astwalk"
+)
+
+// commutativeOps lists the operators under which operand order doesn't
+// change meaning, and so shouldn't change a subtree's canonical hash.
+var commutativeOps = map[string]bool{"&&": true, "||": true, "in": true, "not in": true}
+
+// SubtreeStats is one line of RuleSetMarshaler.Stats(): how often a
+// canonical subtree recurred across the last MarshalRuleSet call, and a
+// sample of what it looks like.
+type SubtreeStats struct {
+	Hash  string
+	Count int
+	Label string
+}
+
+// RuleSetOption configures a RuleSetMarshaler.
+type RuleSetOption func(*RuleSetMarshaler)
+
+// WithMinShared only collapses subtrees that occur, structurally, in at
+// least n distinct rules; subtrees below that threshold get their own
+// node per occurrence instead of being merged. The default is 2: a
+// subtree occurring in a single rule isn't "shared" by anything.
+func WithMinShared(n int) RuleSetOption {
+	return func(m *RuleSetMarshaler) { m.minShared = n }
+}
+
+// RuleSetMarshaler emits a single DOT graph for a whole policy, merging
+// subtrees that are structurally identical - same operator, same
+// operands modulo alpha-equivalence of literal ordering in commutative
+// ops - across different rules into one node with incoming edges from
+// every rule that contains it. This is meant to surface predicates like
+// `process.file.path == "/usr/bin/sudo"` that are duplicated across
+// dozens of rules and are candidates for factoring into macros.
+type RuleSetMarshaler struct {
+	w         io.Writer
+	minShared int
+
+	hashCount map[string]int
+	hashLabel map[string]string
+}
+
+// NewRuleSetMarshaler returns a new RuleSetMarshaler.
+func NewRuleSetMarshaler(w io.Writer, opts ...RuleSetOption) *RuleSetMarshaler {
+	m := &RuleSetMarshaler{w: w, minShared: 2}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// analyzedNode is what analyzeTree computes for one node: its Info (so
+// writeShared never has to re-Visit, and re-allocate, the same synthetic
+// leaves a second time) and its canonical subtree hash.
+type analyzedNode struct {
+	info astwalk.Info
+	hash string
+}
+
+// MarshalRuleSet marshals rules to a single DOT graph, one cluster root
+// per rule, sharing subtree nodes per the RuleSetOptions this marshaler
+// was constructed with. Call Stats() afterwards for a summary of what
+// got shared.
+func (m *RuleSetMarshaler) MarshalRuleSet(rules []*ast.Rule) error {
+	m.hashCount = make(map[string]int)
+	m.hashLabel = make(map[string]string)
+
+	cache := make(map[interface{}]*analyzedNode)
+	for _, r := range rules {
+		if _, err := analyzeTree(r.BooleanExpression, cache); err != nil {
+			return err
+		}
+
+		seen := make(map[string]bool)
+		collectHashes(r.BooleanExpression, cache, seen)
+		for h := range seen {
+			m.hashCount[h]++
+		}
+	}
+
+	if err := m.writeString("digraph {\n"); err != nil {
+		return err
+	}
+
+	written := make(map[string]bool)
+	for i, r := range rules {
+		ruleID := fmt.Sprintf("Rule%d", i)
+		if err := m.writeString(fmt.Sprintf("%s[label=\"Rule %d\" shape=plaintext]\n", ruleID, i)); err != nil {
+			return err
+		}
+
+		rootID, err := m.writeShared(r.BooleanExpression, i, cache, written)
+		if err != nil {
+			return err
+		}
+		if err := m.writeString(ruleID + " -> " + rootID + "\n"); err != nil {
+			return err
+		}
+	}
+
+	return m.writeString("}\n")
+}
+
+// Stats returns, for every subtree hash that MarshalRuleSet actually
+// collapsed (count >= minShared), how many rules it occurred in and a
+// label to identify it by.
+func (m *RuleSetMarshaler) Stats() []SubtreeStats {
+	stats := make([]SubtreeStats, 0, len(m.hashCount))
+	for hash, count := range m.hashCount {
+		if count < m.minShared {
+			continue
+		}
+		stats = append(stats, SubtreeStats{Hash: hash, Count: count, Label: m.hashLabel[hash]})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Count != stats[j].Count {
+			return stats[i].Count > stats[j].Count
+		}
+		return stats[i].Hash < stats[j].Hash
+	})
+	return stats
+}
+
+func (m *RuleSetMarshaler) writeString(s string) error {
+	_, err := io.WriteString(m.w, s)
+	return err
+}
+
+// writeShared writes n (and recursively its children), reusing a single
+// node for any subtree whose hash has met minShared, and returns the id
+// to use for an edge pointing at n. It reads every node's Info and hash
+// from cache rather than re-Visiting n, so it sees the exact same
+// synthetic Op/Ident/Number/String leaves analyzeTree hashed - Visit
+// allocates a new Leaf each time it's called on the same real ast.*
+// node, so a second, independent Visit here would never match what
+// analyzeTree recorded for those leaves.
+func (m *RuleSetMarshaler) writeShared(n interface{}, ruleIdx int, cache map[interface{}]*analyzedNode, written map[string]bool) (string, error) {
+	a := cache[n]
+	id := m.sharedID(a.info, ruleIdx, a.hash)
+
+	if written[id] {
+		return id, nil
+	}
+	written[id] = true
+	m.hashLabel[a.hash] = label(a.info)
+
+	if err := m.writeString(id + "[label=\"" + label(a.info) + "\"]\n"); err != nil {
+		return "", err
+	}
+
+	for _, child := range a.info.Children {
+		childID, err := m.writeShared(child, ruleIdx, cache, written)
+		if err != nil {
+			return "", err
+		}
+		if err := m.writeString(id + " -> " + childID + "\n"); err != nil {
+			return "", err
+		}
+	}
+
+	return id, nil
+}
+
+// sharedID returns a hash-derived id for subtrees that met minShared, so
+// every rule containing them points at the same DOT node, and a
+// per-rule id otherwise.
+func (m *RuleSetMarshaler) sharedID(info astwalk.Info, ruleIdx int, hash string) string {
+	if m.hashCount[hash] >= m.minShared {
+		return "Shared_" + hash[:16]
+	}
+	return fmt.Sprintf("Rule%d_%s", ruleIdx, info.ID)
+}
+
+// analyzeTree computes, for n and every node below it, the Info Visit
+// reports for it and its canonical subtree hash, caching both keyed by
+// the node itself. Visiting each real ast.* node exactly once, here,
+// means the synthetic Leaf objects Visit allocates for Op/Ident/Number/
+// String children are the same objects writeShared later walks -
+// visiting n a second time would allocate different Leaf objects with a
+// different identity, which is exactly what used to make this cache
+// miss for every leaf.
+func analyzeTree(n interface{}, cache map[interface{}]*analyzedNode) (*analyzedNode, error) {
+	if a, ok := cache[n]; ok {
+		return a, nil
+	}
+
+	info, err := astwalk.Visit(astwalk.Default, n)
+	if err != nil {
+		return nil, err
+	}
+
+	var op string
+	var operandHashes []string
+	for _, child := range info.Children {
+		childA, err := analyzeTree(child, cache)
+		if err != nil {
+			return nil, err
+		}
+		if childA.info.Kind == "Op" {
+			op, _ = childA.info.Payload.(string)
+			continue
+		}
+		operandHashes = append(operandHashes, childA.hash)
+	}
+
+	if commutativeOps[op] {
+		sort.Strings(operandHashes)
+	}
+
+	hash := subtreeHash(info.Kind, op, payloadString(info), operandHashes)
+
+	// ast.Expression is a right-recursive &&/|| chain (Comparison, an
+	// optional Op, an optional Next *Expression), so the generic hash
+	// above pairs the first conjunct's bare hash against the *rest of the
+	// chain* wrapped in another Expression-kind hash - two operands, but
+	// asymmetric ones. Sorting them doesn't make a 3+-term chain's hash
+	// independent of conjunct order, because combining two operands at a
+	// time while recursing down the chain groups adjacent conjuncts
+	// together: "a && b && c" groups (b, c) before folding in a, while
+	// "c && b && a" groups (b, a) before folding in c, so the two never
+	// land on the same hash even though every level sorts its pair.
+	// Recompute a commutative Expression's hash directly from the flat,
+	// sorted multiset of every conjunct in its chain instead.
+	if expr, ok := n.(*ast.Expression); ok && expr.Op != nil && expr.Next != nil && commutativeOps[*expr.Op] {
+		flatHash, err := flattenedChainHash(expr, *expr.Op, cache)
+		if err != nil {
+			return nil, err
+		}
+		hash = flatHash
+	}
+
+	a := &analyzedNode{info: info, hash: hash}
+	cache[n] = a
+	return a, nil
+}
+
+// flattenedChainHash returns the canonical hash for the &&/|| chain
+// starting at expr and joined by op. Every segment whose own Op is also
+// op contributes its Comparison's hash as one flat operand before moving
+// on to Next. The chain ends at the first segment whose Op is absent or
+// differs: a segment with no Op contributes its Comparison's hash like
+// every other conjunct, while a segment whose Op differs contributes its
+// own subtree hash - already computed and cached by the analyzeTree call
+// that reached it - as the final operand. Hashing the whole flat list in one pass, rather than folding
+// operands in two at a time as analyzeTree recurses down the chain, is
+// what makes the result depend only on the set of conjuncts and not on
+// the order they were written in.
+func flattenedChainHash(expr *ast.Expression, op string, cache map[interface{}]*analyzedNode) (string, error) {
+	var operandHashes []string
+
+	cur := expr
+	for cur.Op != nil && *cur.Op == op && cur.Next != nil {
+		comparisonA, err := analyzeTree(cur.Comparison, cache)
+		if err != nil {
+			return "", err
+		}
+		operandHashes = append(operandHashes, comparisonA.hash)
+		cur = cur.Next
+	}
+
+	if cur.Op == nil {
+		comparisonA, err := analyzeTree(cur.Comparison, cache)
+		if err != nil {
+			return "", err
+		}
+		operandHashes = append(operandHashes, comparisonA.hash)
+	} else {
+		tailA, err := analyzeTree(cur, cache)
+		if err != nil {
+			return "", err
+		}
+		operandHashes = append(operandHashes, tailA.hash)
+	}
+
+	sort.Strings(operandHashes)
+	return subtreeHash("Expression", op, "", operandHashes), nil
+}
+
+// collectHashes walks n using the Info already cached by analyzeTree,
+// recording every distinct subtree hash found below it into seen.
+func collectHashes(n interface{}, cache map[interface{}]*analyzedNode, seen map[string]bool) {
+	a := cache[n]
+	seen[a.hash] = true
+	for _, child := range a.info.Children {
+		collectHashes(child, cache, seen)
+	}
+}
+
+// payloadString renders a leaf's payload for hashing, sorting literal
+// arrays first since `in` never cares about their order.
+func payloadString(info astwalk.Info) string {
+	switch p := info.Payload.(type) {
+	case nil:
+		return ""
+	case []string:
+		ss := append([]string(nil), p...)
+		sort.Strings(ss)
+		return strings.Join(ss, ",")
+	case []int:
+		ns := append([]int(nil), p...)
+		sort.Ints(ns)
+		parts := make([]string, len(ns))
+		for i, v := range ns {
+			parts[i] = strconv.Itoa(v)
+		}
+		return strings.Join(parts, ",")
+	default:
+		return fmt.Sprintf("%v", p)
+	}
+}
+
+func subtreeHash(kind, op, payload string, operandHashes []string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s", kind, op, payload, strings.Join(operandHashes, "\x00"))
+	return hex.EncodeToString(h.Sum(nil))
+}
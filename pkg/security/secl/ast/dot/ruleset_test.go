@@ -0,0 +1,206 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+package dot
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/security/secl/ast"
+)
+
+// sudoPathRule builds the AST for `process.file.path == "/usr/bin/sudo"`.
+func sudoPathRule() *ast.Rule {
+	ident := "process.file.path"
+	op := "=="
+	path := "/usr/bin/sudo"
+
+	return &ast.Rule{
+		BooleanExpression: &ast.BooleanExpression{
+			Expression: &ast.Expression{
+				Comparison: &ast.Comparison{
+					BitOperation: &ast.BitOperation{
+						Unary: &ast.Unary{
+							Primary: &ast.Primary{Ident: &ident},
+						},
+					},
+					ScalarComparison: &ast.ScalarComparison{
+						Op: &op,
+						Next: &ast.BitOperation{
+							Unary: &ast.Unary{
+								Primary: &ast.Primary{String: &path},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestMarshalRuleSetSharesIdenticalSubtrees(t *testing.T) {
+	rules := []*ast.Rule{sudoPathRule(), sudoPathRule()}
+
+	var sb strings.Builder
+	m := NewRuleSetMarshaler(&sb)
+
+	if err := m.MarshalRuleSet(rules); err != nil {
+		t.Fatalf("MarshalRuleSet: %v", err)
+	}
+
+	out := sb.String()
+	if strings.Count(out, `label="Ident\nprocess.file.path"`) != 1 {
+		t.Fatalf("expected the duplicated Ident node to be emitted once, got: %q", out)
+	}
+	if strings.Count(out, "-> Shared_") < 2 {
+		t.Fatalf("expected both rules to point at the shared subtree, got: %q", out)
+	}
+
+	stats := m.Stats()
+	if len(stats) == 0 {
+		t.Fatalf("expected Stats to report the shared subtree")
+	}
+	if stats[0].Count != 2 {
+		t.Fatalf("got count %d for the most-shared subtree, want 2", stats[0].Count)
+	}
+}
+
+// identOpRule builds the AST for `ident == str`.
+func identOpRule(ident, str string) *ast.Rule {
+	op := "=="
+	return &ast.Rule{
+		BooleanExpression: &ast.BooleanExpression{
+			Expression: &ast.Expression{
+				Comparison: &ast.Comparison{
+					BitOperation: &ast.BitOperation{
+						Unary: &ast.Unary{
+							Primary: &ast.Primary{Ident: &ident},
+						},
+					},
+					ScalarComparison: &ast.ScalarComparison{
+						Op: &op,
+						Next: &ast.BitOperation{
+							Unary: &ast.Unary{
+								Primary: &ast.Primary{String: &str},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestMarshalRuleSetSharesPartialSubtreeWithLabel(t *testing.T) {
+	rules := []*ast.Rule{
+		identOpRule("a", "x"),
+		identOpRule("b", "y"),
+	}
+
+	var sb strings.Builder
+	m := NewRuleSetMarshaler(&sb)
+	if err := m.MarshalRuleSet(rules); err != nil {
+		t.Fatalf("MarshalRuleSet: %v", err)
+	}
+
+	var opStats *SubtreeStats
+	for _, s := range m.Stats() {
+		if s.Label == `Op\n==` {
+			stat := s
+			opStats = &stat
+		}
+	}
+	if opStats == nil {
+		t.Fatalf("expected Stats to report the shared Op leaf with a label, got: %v", m.Stats())
+	}
+	if opStats.Count != 2 {
+		t.Fatalf("got count %d for the shared Op leaf, want 2", opStats.Count)
+	}
+	if opStats.Hash == "" {
+		t.Fatalf("expected the shared Op leaf to have a real hash")
+	}
+}
+
+// conjunctionRule builds the AST for `idents[0] == strs[0] && idents[1] ==
+// strs[1] && ...`, joining comparisons with op (expected to be "&&" or
+// "||") in the order given, as the right-recursive ast.Expression chain
+// the parser itself would produce.
+func conjunctionRule(op string, idents, strs []string) *ast.Rule {
+	var expr *ast.Expression
+	for i := len(idents) - 1; i >= 0; i-- {
+		ident, str := idents[i], strs[i]
+		eq := "=="
+		next := &ast.Expression{
+			Comparison: &ast.Comparison{
+				BitOperation: &ast.BitOperation{
+					Unary: &ast.Unary{Primary: &ast.Primary{Ident: &ident}},
+				},
+				ScalarComparison: &ast.ScalarComparison{
+					Op:   &eq,
+					Next: &ast.BitOperation{Unary: &ast.Unary{Primary: &ast.Primary{String: &str}}},
+				},
+			},
+		}
+		if expr != nil {
+			chainOp := op
+			next.Op = &chainOp
+			next.Next = expr
+		}
+		expr = next
+	}
+	return &ast.Rule{BooleanExpression: &ast.BooleanExpression{Expression: expr}}
+}
+
+// TestMarshalRuleSetSharesReorderedConjunction proves that reordering the
+// conjuncts of a multi-term &&/|| chain doesn't stop two rules from being
+// recognized as the same subtree: ast.Expression's right-recursive shape
+// used to make the hash depend on which conjunct came first in the
+// source rule, not just on the set of conjuncts.
+func TestMarshalRuleSetSharesReorderedConjunction(t *testing.T) {
+	rules := []*ast.Rule{
+		conjunctionRule("&&", []string{"a", "b", "c"}, []string{"x", "y", "z"}),
+		conjunctionRule("&&", []string{"c", "b", "a"}, []string{"z", "y", "x"}),
+	}
+
+	var sb strings.Builder
+	m := NewRuleSetMarshaler(&sb)
+	if err := m.MarshalRuleSet(rules); err != nil {
+		t.Fatalf("MarshalRuleSet: %v", err)
+	}
+
+	out := sb.String()
+	i0 := strings.Index(out, "Rule0 -> ")
+	i1 := strings.Index(out, "Rule1 -> ")
+	if i0 < 0 || i1 < 0 {
+		t.Fatalf("expected both rules to have a root edge, got: %q", out)
+	}
+	root0 := strings.Fields(out[i0+len("Rule0 -> "):])[0]
+	root1 := strings.Fields(out[i1+len("Rule1 -> "):])[0]
+	if root0 != root1 {
+		t.Fatalf("expected reordered conjunctions to share one root node, got %q and %q", root0, root1)
+	}
+	if !strings.HasPrefix(root0, "Shared_") {
+		t.Fatalf("expected the shared root to use a hash-derived id, got %q", root0)
+	}
+}
+
+func TestMarshalRuleSetRespectsMinShared(t *testing.T) {
+	rules := []*ast.Rule{sudoPathRule(), sudoPathRule()}
+
+	var sb strings.Builder
+	m := NewRuleSetMarshaler(&sb, WithMinShared(3))
+	if err := m.MarshalRuleSet(rules); err != nil {
+		t.Fatalf("MarshalRuleSet: %v", err)
+	}
+
+	out := sb.String()
+	if strings.Count(out, `label="Ident\nprocess.file.path"`) != 2 {
+		t.Fatalf("expected no sharing below the minShared threshold, got: %q", out)
+	}
+	if len(m.Stats()) != 0 {
+		t.Fatalf("expected Stats to report nothing below minShared, got: %v", m.Stats())
+	}
+}
@@ -0,0 +1,338 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+package dot
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/DataDog/datadog-agent/pkg/security/secl/ast"
+)
+
+var (
+	nodeLineRe = regexp.MustCompile(`^(\S+)\[label="(.*)"\]$`)
+	edgeLineRe = regexp.MustCompile(`^(\S+) -> (\S+)$`)
+)
+
+// Unmarshaler reconstructs an *ast.Rule from the DOT text emitted by
+// Marshaler.MarshalRule. It is a small handwritten reader rather than a
+// wrapper around an external DOT/graphviz parsing library.
+type Unmarshaler struct {
+	r io.Reader
+}
+
+// NewUnmarshaler returns a new rule DOT unmarshaler.
+func NewUnmarshaler(r io.Reader) *Unmarshaler {
+	return &Unmarshaler{r: r}
+}
+
+type rawNode struct {
+	label    string
+	children []string
+}
+
+// UnmarshalRule parses DOT produced by Marshaler.MarshalRule and
+// reconstructs the *ast.Rule it was generated from.
+func (u *Unmarshaler) UnmarshalRule() (*ast.Rule, error) {
+	nodes, order, err := parseDigraph(u.r)
+	if err != nil {
+		return nil, err
+	}
+	if len(order) == 0 {
+		return nil, fmt.Errorf("dot: empty graph")
+	}
+
+	be, err := buildBooleanExpression(order[0], nodes)
+	if err != nil {
+		return nil, err
+	}
+	return &ast.Rule{BooleanExpression: be}, nil
+}
+
+// parseDigraph reads a "digraph { ... }" body line by line, collecting
+// every "id[label=\"...\"]" node declaration and "parent -> child" edge.
+// order records node ids in the order they were first mentioned, so the
+// first one is always the graph's root: Marshaler.writeNode always
+// writes a node's own label line before any of its descendants'.
+func parseDigraph(r io.Reader) (map[string]*rawNode, []string, error) {
+	nodes := make(map[string]*rawNode)
+	var order []string
+
+	ensure := func(id string) *rawNode {
+		n, ok := nodes[id]
+		if !ok {
+			n = &rawNode{}
+			nodes[id] = n
+			order = append(order, id)
+		}
+		return n
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line == "digraph {" || line == "}":
+			continue
+		case nodeLineRe.MatchString(line):
+			m := nodeLineRe.FindStringSubmatch(line)
+			ensure(m[1]).label = m[2]
+		case edgeLineRe.MatchString(line):
+			m := edgeLineRe.FindStringSubmatch(line)
+			parent := ensure(m[1])
+			ensure(m[2])
+			parent.children = append(parent.children, m[2])
+		default:
+			return nil, nil, fmt.Errorf("dot: unrecognized line: %q", line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return nodes, order, nil
+}
+
+// splitLabel splits a node label of the form "Kind\\nPayload" back into
+// its kind and payload. Labels with no "\\n" (every container node) are
+// returned as-is with an empty payload.
+func splitLabel(label string) (string, string) {
+	if parts := strings.SplitN(label, `\n`, 2); len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return label, ""
+}
+
+func buildBooleanExpression(id string, nodes map[string]*rawNode) (*ast.BooleanExpression, error) {
+	n, ok := nodes[id]
+	if !ok || len(n.children) != 1 {
+		return nil, fmt.Errorf("dot: BooleanExpression node %q has %d children, want 1", id, len(n.children))
+	}
+	expr, err := buildExpression(n.children[0], nodes)
+	if err != nil {
+		return nil, err
+	}
+	return &ast.BooleanExpression{Expression: expr}, nil
+}
+
+func buildExpression(id string, nodes map[string]*rawNode) (*ast.Expression, error) {
+	n, ok := nodes[id]
+	if !ok || len(n.children) == 0 {
+		return nil, fmt.Errorf("dot: Expression node %q has no children", id)
+	}
+
+	comparison, err := buildComparison(n.children[0], nodes)
+	if err != nil {
+		return nil, err
+	}
+	expr := &ast.Expression{Comparison: comparison}
+
+	for _, childID := range n.children[1:] {
+		kind, payload := splitLabel(nodes[childID].label)
+		if kind == "Op" {
+			op := payload
+			expr.Op = &op
+			continue
+		}
+		next, err := buildExpression(childID, nodes)
+		if err != nil {
+			return nil, err
+		}
+		expr.Next = next
+	}
+
+	return expr, nil
+}
+
+func buildComparison(id string, nodes map[string]*rawNode) (*ast.Comparison, error) {
+	n, ok := nodes[id]
+	if !ok || len(n.children) == 0 {
+		return nil, fmt.Errorf("dot: Comparison node %q has no children", id)
+	}
+
+	bitOperation, err := buildBitOperation(n.children[0], nodes)
+	if err != nil {
+		return nil, err
+	}
+	c := &ast.Comparison{BitOperation: bitOperation}
+
+	for _, childID := range n.children[1:] {
+		kind, _ := splitLabel(nodes[childID].label)
+		switch kind {
+		case "ArrayComparison":
+			ac, err := buildArrayComparison(childID, nodes)
+			if err != nil {
+				return nil, err
+			}
+			c.ArrayComparison = ac
+		case "ScalarComparison":
+			sc, err := buildScalarComparison(childID, nodes)
+			if err != nil {
+				return nil, err
+			}
+			c.ScalarComparison = sc
+		default:
+			return nil, fmt.Errorf("dot: unexpected Comparison child kind %q", kind)
+		}
+	}
+
+	return c, nil
+}
+
+func buildArrayComparison(id string, nodes map[string]*rawNode) (*ast.ArrayComparison, error) {
+	n, ok := nodes[id]
+	if !ok || len(n.children) != 2 {
+		return nil, fmt.Errorf("dot: ArrayComparison node %q has %d children, want 2", id, len(n.children))
+	}
+	_, op := splitLabel(nodes[n.children[0]].label)
+	array, err := buildArray(n.children[1], nodes)
+	if err != nil {
+		return nil, err
+	}
+	return &ast.ArrayComparison{Op: &op, Array: array}, nil
+}
+
+func buildScalarComparison(id string, nodes map[string]*rawNode) (*ast.ScalarComparison, error) {
+	n, ok := nodes[id]
+	if !ok || len(n.children) != 2 {
+		return nil, fmt.Errorf("dot: ScalarComparison node %q has %d children, want 2", id, len(n.children))
+	}
+	_, op := splitLabel(nodes[n.children[0]].label)
+	next, err := buildBitOperation(n.children[1], nodes)
+	if err != nil {
+		return nil, err
+	}
+	return &ast.ScalarComparison{Op: &op, Next: next}, nil
+}
+
+func buildArray(id string, nodes map[string]*rawNode) (*ast.Array, error) {
+	n, ok := nodes[id]
+	if !ok || len(n.children) != 1 {
+		return nil, fmt.Errorf("dot: Array node %q has %d children, want 1", id, len(n.children))
+	}
+
+	kind, raw := splitLabel(nodes[n.children[0]].label)
+	if raw == "" {
+		return &ast.Array{}, nil
+	}
+
+	tokens := strings.Split(raw, ",")
+	for i, tok := range tokens {
+		tokens[i] = strings.TrimSpace(tok)
+	}
+
+	switch kind {
+	case "NumberArray":
+		numbers := make([]int, len(tokens))
+		for i, tok := range tokens {
+			v, err := strconv.Atoi(tok)
+			if err != nil {
+				return nil, fmt.Errorf("dot: invalid NumberArray element %q: %w", tok, err)
+			}
+			numbers[i] = v
+		}
+		return &ast.Array{Numbers: numbers}, nil
+	case "StringArray":
+		return &ast.Array{Strings: tokens}, nil
+	default:
+		return nil, fmt.Errorf("dot: unexpected Array child kind %q", kind)
+	}
+}
+
+func buildBitOperation(id string, nodes map[string]*rawNode) (*ast.BitOperation, error) {
+	n, ok := nodes[id]
+	if !ok || len(n.children) == 0 {
+		return nil, fmt.Errorf("dot: BitOperation node %q has no children", id)
+	}
+
+	unary, err := buildUnary(n.children[0], nodes)
+	if err != nil {
+		return nil, err
+	}
+	bo := &ast.BitOperation{Unary: unary}
+
+	for _, childID := range n.children[1:] {
+		kind, payload := splitLabel(nodes[childID].label)
+		if kind == "Op" {
+			op := payload
+			bo.Op = &op
+			continue
+		}
+		next, err := buildBitOperation(childID, nodes)
+		if err != nil {
+			return nil, err
+		}
+		bo.Next = next
+	}
+
+	return bo, nil
+}
+
+func buildUnary(id string, nodes map[string]*rawNode) (*ast.Unary, error) {
+	n, ok := nodes[id]
+	if !ok {
+		return nil, fmt.Errorf("dot: no such Unary node %q", id)
+	}
+	u := &ast.Unary{}
+
+	for _, childID := range n.children {
+		kind, payload := splitLabel(nodes[childID].label)
+		switch kind {
+		case "Op":
+			op := payload
+			u.Op = &op
+		case "Unary":
+			next, err := buildUnary(childID, nodes)
+			if err != nil {
+				return nil, err
+			}
+			u.Unary = next
+		case "Primary":
+			primary, err := buildPrimary(childID, nodes)
+			if err != nil {
+				return nil, err
+			}
+			u.Primary = primary
+		default:
+			return nil, fmt.Errorf("dot: unexpected Unary child kind %q", kind)
+		}
+	}
+
+	return u, nil
+}
+
+func buildPrimary(id string, nodes map[string]*rawNode) (*ast.Primary, error) {
+	n, ok := nodes[id]
+	if !ok || len(n.children) != 1 {
+		return nil, fmt.Errorf("dot: Primary node %q has %d children, want 1", id, len(n.children))
+	}
+
+	child := nodes[n.children[0]]
+	kind, payload := splitLabel(child.label)
+
+	switch kind {
+	case "Ident":
+		return &ast.Primary{Ident: &payload}, nil
+	case "String":
+		return &ast.Primary{String: &payload}, nil
+	case "Number":
+		v, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("dot: invalid Number leaf %q: %w", payload, err)
+		}
+		return &ast.Primary{Number: &v}, nil
+	default:
+		sub, err := buildExpression(n.children[0], nodes)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.Primary{SubExpression: sub}, nil
+	}
+}
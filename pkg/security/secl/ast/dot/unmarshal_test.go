@@ -0,0 +1,142 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+package dot
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/security/secl/ast"
+)
+
+func TestUnmarshalRuleRoundTrips(t *testing.T) {
+	ident := "process.file.path"
+	op := "=="
+	path := "/usr/bin/sudo"
+
+	rule := &ast.Rule{
+		BooleanExpression: &ast.BooleanExpression{
+			Expression: &ast.Expression{
+				Comparison: &ast.Comparison{
+					BitOperation: &ast.BitOperation{
+						Unary: &ast.Unary{
+							Primary: &ast.Primary{Ident: &ident},
+						},
+					},
+					ScalarComparison: &ast.ScalarComparison{
+						Op: &op,
+						Next: &ast.BitOperation{
+							Unary: &ast.Unary{
+								Primary: &ast.Primary{String: &path},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	var sb strings.Builder
+	if err := NewMarshaler(&sb).MarshalRule(rule); err != nil {
+		t.Fatalf("MarshalRule: %v", err)
+	}
+
+	got, err := NewUnmarshaler(strings.NewReader(sb.String())).UnmarshalRule()
+	if err != nil {
+		t.Fatalf("UnmarshalRule: %v", err)
+	}
+
+	// Node ids embed node pointers, so re-marshaling the rebuilt AST
+	// yields different ids; compare the ASTs themselves instead.
+	if !reflect.DeepEqual(got, rule) {
+		t.Fatalf("round-trip mismatch:\noriginal:  %+v\nrecovered: %+v", rule, got)
+	}
+}
+
+func TestUnmarshalRuleWithArray(t *testing.T) {
+	ident := "open.flags"
+	op := "in"
+
+	rule := &ast.Rule{
+		BooleanExpression: &ast.BooleanExpression{
+			Expression: &ast.Expression{
+				Comparison: &ast.Comparison{
+					BitOperation: &ast.BitOperation{
+						Unary: &ast.Unary{
+							Primary: &ast.Primary{Ident: &ident},
+						},
+					},
+					ArrayComparison: &ast.ArrayComparison{
+						Op:    &op,
+						Array: &ast.Array{Numbers: []int{1, 2, 3}},
+					},
+				},
+			},
+		},
+	}
+
+	var sb strings.Builder
+	if err := NewMarshaler(&sb).MarshalRule(rule); err != nil {
+		t.Fatalf("MarshalRule: %v", err)
+	}
+
+	got, err := NewUnmarshaler(strings.NewReader(sb.String())).UnmarshalRule()
+	if err != nil {
+		t.Fatalf("UnmarshalRule: %v", err)
+	}
+
+	numbers := got.BooleanExpression.Expression.Comparison.ArrayComparison.Array.Numbers
+	if len(numbers) != 3 || numbers[0] != 1 || numbers[1] != 2 || numbers[2] != 3 {
+		t.Fatalf("got numbers %v, want [1 2 3]", numbers)
+	}
+}
+
+// TestUnmarshalRuleWithNumericLookingStringArray guards against
+// buildArray guessing Strings vs Numbers from content: a one-element
+// string array whose element happens to look like an integer, e.g.
+// `x in ["123"]`, must round-trip as a string array, not silently become
+// a number array.
+func TestUnmarshalRuleWithNumericLookingStringArray(t *testing.T) {
+	ident := "exec.pid"
+	op := "in"
+
+	rule := &ast.Rule{
+		BooleanExpression: &ast.BooleanExpression{
+			Expression: &ast.Expression{
+				Comparison: &ast.Comparison{
+					BitOperation: &ast.BitOperation{
+						Unary: &ast.Unary{
+							Primary: &ast.Primary{Ident: &ident},
+						},
+					},
+					ArrayComparison: &ast.ArrayComparison{
+						Op:    &op,
+						Array: &ast.Array{Strings: []string{"123"}},
+					},
+				},
+			},
+		},
+	}
+
+	var sb strings.Builder
+	if err := NewMarshaler(&sb).MarshalRule(rule); err != nil {
+		t.Fatalf("MarshalRule: %v", err)
+	}
+
+	got, err := NewUnmarshaler(strings.NewReader(sb.String())).UnmarshalRule()
+	if err != nil {
+		t.Fatalf("UnmarshalRule: %v", err)
+	}
+
+	array := got.BooleanExpression.Expression.Comparison.ArrayComparison.Array
+	if len(array.Numbers) != 0 {
+		t.Fatalf("got numbers %v, want none - a numeric-looking string element must not become a Number", array.Numbers)
+	}
+	if len(array.Strings) != 1 || array.Strings[0] != "123" {
+		t.Fatalf("got strings %v, want [\"123\"]", array.Strings)
+	}
+}
@@ -0,0 +1,134 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// Package mermaid converts a secl rule AST to a Mermaid `graph TD`
+// diagram that can be embedded directly in rule documentation, e.g. in
+// a Markdown ```mermaid fenced block.
+package mermaid
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/DataDog/datadog-agent/pkg/security/secl/ast"
+	"github.com/DataDog/datadog-agent/pkg/security/secl/astwalk"
+	"github.com/DataDog/datadog-agent/pkg/security/secl/eval"
+)
+
+// Marshaler converts a rule to a Mermaid flowchart. The shape of the
+// tree (node ids, children) comes from astwalk.Default, the same as
+// dot.Marshaler; this type only knows how to render it as Mermaid.
+type Marshaler struct {
+	w io.Writer
+}
+
+// NewMarshaler returns a new rule Mermaid marshaler
+func NewMarshaler(w io.Writer) *Marshaler {
+	return &Marshaler{w: w}
+}
+
+// MarshalRule marshals the AST of a rule to a Mermaid `graph TD` diagram
+func (m *Marshaler) MarshalRule(r *ast.Rule) error {
+	return m.marshalRule(r, nil)
+}
+
+// MarshalRuleWithTrace marshals the AST of a rule to a Mermaid `graph TD`
+// diagram, styling each node according to what trace recorded when the
+// rule was evaluated against a concrete event. See
+// dot.Marshaler.MarshalRuleWithTrace for the coloring rules.
+func (m *Marshaler) MarshalRuleWithTrace(r *ast.Rule, trace *eval.Trace) error {
+	return m.marshalRule(r, trace)
+}
+
+func (m *Marshaler) marshalRule(r *ast.Rule, trace *eval.Trace) error {
+	if err := m.writeString("graph TD\n"); err != nil {
+		return err
+	}
+	fn := func(n interface{}, info astwalk.Info) error { return m.writeNode(info, trace) }
+	return astwalk.Walk(astwalk.Default, r.BooleanExpression, fn)
+}
+
+func (m *Marshaler) writeString(s string) error {
+	_, err := io.WriteString(m.w, s)
+	return err
+}
+
+func (m *Marshaler) writeNode(info astwalk.Info, trace *eval.Trace) error {
+	// Synthetic leaf nodes (Op, Ident, Number, String, ...) aren't
+	// Traceable: they don't correspond to a single ast.* node with its
+	// own offset, so looking them up in trace would misattribute
+	// whatever trace recorded at offset 0 to every leaf in the rule.
+	nt, traced := eval.NodeTrace{}, false
+	if info.Traceable {
+		nt, traced = trace.Lookup(info.Offset)
+	}
+
+	nodeLabel := label(info)
+	if traced && (nt.Left != nil || nt.Right != nil) {
+		nodeLabel = fmt.Sprintf("%s<br/>%v vs %v", nodeLabel, nt.Left, nt.Right)
+	}
+	if err := m.writeString(fmt.Sprintf("  %s[%q]\n", info.ID, nodeLabel)); err != nil {
+		return err
+	}
+
+	for _, child := range info.Children {
+		childInfo, err := astwalk.Visit(astwalk.Default, child)
+		if err != nil {
+			return err
+		}
+		if err := m.writeString(fmt.Sprintf("  %s --> %s\n", info.ID, childInfo.ID)); err != nil {
+			return err
+		}
+	}
+
+	if traced {
+		if err := m.writeString(fmt.Sprintf("  style %s fill:%s\n", info.ID, traceColor(nt.Result))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func traceColor(result eval.Result) string {
+	switch result {
+	case eval.True:
+		return "#9f6"
+	case eval.False:
+		return "#f66"
+	default:
+		return "#ccc"
+	}
+}
+
+// label renders a node's Info as a Mermaid node label. Container nodes
+// (Rule, Expression, ...) are labeled with their kind; leaf kinds get
+// their payload on a second line, separated by a <br/> since Mermaid
+// node labels don't honor literal newlines.
+func label(info astwalk.Info) string {
+	switch info.Kind {
+	case "Op":
+		return fmt.Sprintf("Op<br/>%s", info.Payload)
+	case "Ident":
+		return fmt.Sprintf("Ident<br/>%s", info.Payload)
+	case "Number":
+		return fmt.Sprintf("Number<br/>%d", info.Payload)
+	case "String":
+		return fmt.Sprintf("String<br/>%s", info.Payload)
+	case "StringArray":
+		return fmt.Sprintf("StringArray<br/>%s", strings.Join(info.Payload.([]string), ","))
+	case "NumberArray":
+		numbers := info.Payload.([]int)
+		parts := make([]string, len(numbers))
+		for i, n := range numbers {
+			parts[i] = strconv.Itoa(n)
+		}
+		return fmt.Sprintf("NumberArray<br/>%s", strings.Join(parts, ", "))
+	default:
+		return info.Kind
+	}
+}
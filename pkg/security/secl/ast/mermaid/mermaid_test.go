@@ -0,0 +1,54 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+package mermaid
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/security/secl/ast/asttest"
+	"github.com/DataDog/datadog-agent/pkg/security/secl/eval"
+)
+
+func TestMarshalRule(t *testing.T) {
+	rule := asttest.IdentEqualsString()
+
+	var sb strings.Builder
+	if err := NewMarshaler(&sb).MarshalRule(rule); err != nil {
+		t.Fatalf("MarshalRule: %v", err)
+	}
+
+	out := sb.String()
+	if !strings.HasPrefix(out, "graph TD\n") {
+		t.Fatalf("output is not a Mermaid flowchart: %q", out)
+	}
+	if !strings.Contains(out, "-->") {
+		t.Fatalf("missing edges in output: %q", out)
+	}
+	if !strings.Contains(out, `"Ident<br/>a"`) {
+		t.Fatalf("missing Ident leaf in output: %q", out)
+	}
+}
+
+func TestMarshalRuleWithTrace(t *testing.T) {
+	rule := asttest.TracedIdentEqualsString()
+
+	trace := eval.NewTrace()
+	trace.RecordComparison(42, eval.False, "a", "x")
+
+	var sb strings.Builder
+	if err := NewMarshaler(&sb).MarshalRuleWithTrace(rule, trace); err != nil {
+		t.Fatalf("MarshalRuleWithTrace: %v", err)
+	}
+
+	out := sb.String()
+	if !strings.Contains(out, "fill:#f66") {
+		t.Fatalf("expected the traced comparison node to be styled red: %q", out)
+	}
+	if !strings.Contains(out, "a vs x") {
+		t.Fatalf("expected the traced comparison node to be annotated with its operands: %q", out)
+	}
+}
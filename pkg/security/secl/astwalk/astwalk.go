@@ -0,0 +1,298 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// Package astwalk describes the shape of a secl/ast tree once, so that
+// every output format (DOT, Mermaid, JSON, ...) can walk it the same
+// way instead of re-implementing its own id/label/children switches.
+// Formats only ever call Visit/Walk with a Visitor; they never switch on
+// concrete ast.* types themselves, so swapping in a different Visitor
+// changes what every format renders without touching any of them. The
+// one exception is dot.RuleSetMarshaler's chain hashing, which reads
+// *ast.Expression's Op and Next directly.
+package astwalk
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/DataDog/datadog-agent/pkg/security/secl/ast"
+)
+
+// Leaf is a synthetic, non-ast.* node. It gives an operator, identifier,
+// number or literal array its own place in the walked tree, the same
+// way the original dot.Marshaler used to synthesize "Op", "Ident", ...
+// nodes by hand.
+type Leaf struct {
+	ID      string
+	Kind    string
+	Payload interface{}
+}
+
+// Info is everything a Visitor knows about one node: the graph node id
+// to use for it, its semantic kind (the ast.* type name, or one of the
+// synthetic leaf kinds such as "Op" or "Ident"), its source offset when
+// it has one, the payload to render for leaf kinds, and the children to
+// recurse into next. Marshalers turn an Info into their own syntax; they
+// never need to derive node ids or children on their own.
+type Info struct {
+	ID       string
+	Kind     string
+	Offset   int
+	Payload  interface{}
+	Children []interface{}
+
+	// Traceable is true for every real ast.* node, whose Offset is that
+	// node's own Pos.Offset, and false for the synthetic leaf kinds (Op,
+	// Ident, Number, String, StringArray, NumberArray), which don't
+	// correspond to a single ast.* node with its own position. An
+	// eval.Trace is keyed by ast.* node offset, so marshalers must only
+	// look up a trace result when Traceable is true - otherwise every
+	// leaf reports the offset-0 default and gets stamped with whatever
+	// trace entry happens to live at offset 0.
+	Traceable bool
+}
+
+// Visitor describes every secl/ast node kind once. It is the single
+// place that knows the shape of the AST: adding a new ast.* node type
+// means adding one method here and one case to Visit's type switch,
+// instead of a new case in every marshaler's getID/getLabel/getChildren/
+// writeNode switch - and format packages never need a code change at all
+// for a new node kind their own label() falls back to rendering by Kind.
+type Visitor interface {
+	VisitRule(n *ast.Rule) (Info, error)
+	VisitExpression(n *ast.Expression) (Info, error)
+	VisitBooleanExpression(n *ast.BooleanExpression) (Info, error)
+	VisitComparison(n *ast.Comparison) (Info, error)
+	VisitArrayComparison(n *ast.ArrayComparison) (Info, error)
+	VisitScalarComparison(n *ast.ScalarComparison) (Info, error)
+	VisitArray(n *ast.Array) (Info, error)
+	VisitBitOperation(n *ast.BitOperation) (Info, error)
+	VisitUnary(n *ast.Unary) (Info, error)
+	VisitPrimary(n *ast.Primary) (Info, error)
+	VisitLeaf(n *Leaf) (Info, error)
+}
+
+// Default is the canonical Visitor: a format-agnostic description of the
+// secl/ast tree shared by every marshaler in this repo (dot, mermaid,
+// astjson). Formats render Info.Kind/Info.Payload however suits their
+// own syntax; none of them re-derive node ids or children on their own.
+var Default Visitor = structureVisitor{}
+
+type structureVisitor struct{}
+
+func (structureVisitor) VisitRule(n *ast.Rule) (Info, error) {
+	return Info{
+		ID:        nodeID("Rule", n.Pos.Offset, n),
+		Kind:      "Rule",
+		Offset:    n.Pos.Offset,
+		Traceable: true,
+		Children:  []interface{}{n.BooleanExpression},
+	}, nil
+}
+
+func (structureVisitor) VisitExpression(n *ast.Expression) (Info, error) {
+	children := []interface{}{n.Comparison}
+	if n.Op != nil {
+		children = append(children, opLeaf(n.Op))
+	}
+	if n.Next != nil {
+		children = append(children, n.Next)
+	}
+	return Info{
+		ID:        nodeID("Expression", n.Pos.Offset, n),
+		Kind:      "Expression",
+		Offset:    n.Pos.Offset,
+		Traceable: true,
+		Children:  children,
+	}, nil
+}
+
+func (structureVisitor) VisitBooleanExpression(n *ast.BooleanExpression) (Info, error) {
+	return Info{
+		ID:        nodeID("BooleanExpression", n.Pos.Offset, n),
+		Kind:      "BooleanExpression",
+		Offset:    n.Pos.Offset,
+		Traceable: true,
+		Children:  []interface{}{n.Expression},
+	}, nil
+}
+
+func (structureVisitor) VisitComparison(n *ast.Comparison) (Info, error) {
+	children := []interface{}{n.BitOperation}
+	if n.ArrayComparison != nil {
+		children = append(children, n.ArrayComparison)
+	}
+	if n.ScalarComparison != nil {
+		children = append(children, n.ScalarComparison)
+	}
+	return Info{
+		ID:        nodeID("Comparison", n.Pos.Offset, n),
+		Kind:      "Comparison",
+		Offset:    n.Pos.Offset,
+		Traceable: true,
+		Children:  children,
+	}, nil
+}
+
+func (structureVisitor) VisitArrayComparison(n *ast.ArrayComparison) (Info, error) {
+	return Info{
+		ID:        nodeID("ArrayComparison", n.Pos.Offset, n),
+		Kind:      "ArrayComparison",
+		Offset:    n.Pos.Offset,
+		Traceable: true,
+		Children:  []interface{}{opLeaf(n.Op), n.Array},
+	}, nil
+}
+
+func (structureVisitor) VisitScalarComparison(n *ast.ScalarComparison) (Info, error) {
+	return Info{
+		ID:        nodeID("ScalarComparison", n.Pos.Offset, n),
+		Kind:      "ScalarComparison",
+		Offset:    n.Pos.Offset,
+		Traceable: true,
+		Children:  []interface{}{opLeaf(n.Op), n.Next},
+	}, nil
+}
+
+func (structureVisitor) VisitArray(n *ast.Array) (Info, error) {
+	var leaf *Leaf
+	if len(n.Strings) > 0 {
+		leaf = &Leaf{ID: fmt.Sprintf("Array%p", n), Kind: "StringArray", Payload: n.Strings}
+	} else {
+		leaf = &Leaf{ID: fmt.Sprintf("Array%p", n), Kind: "NumberArray", Payload: n.Numbers}
+	}
+	return Info{
+		ID:        nodeID("Array", n.Pos.Offset, n),
+		Kind:      "Array",
+		Offset:    n.Pos.Offset,
+		Traceable: true,
+		Children:  []interface{}{leaf},
+	}, nil
+}
+
+func (structureVisitor) VisitBitOperation(n *ast.BitOperation) (Info, error) {
+	children := []interface{}{n.Unary}
+	if n.Op != nil {
+		children = append(children, opLeaf(n.Op))
+	}
+	if n.Next != nil {
+		children = append(children, n.Next)
+	}
+	return Info{
+		ID:        nodeID("BitOperation", n.Pos.Offset, n),
+		Kind:      "BitOperation",
+		Offset:    n.Pos.Offset,
+		Traceable: true,
+		Children:  children,
+	}, nil
+}
+
+func (structureVisitor) VisitUnary(n *ast.Unary) (Info, error) {
+	var children []interface{}
+	if n.Op != nil {
+		children = append(children, opLeaf(n.Op))
+	}
+	if n.Unary != nil {
+		children = append(children, n.Unary)
+	}
+	if n.Primary != nil {
+		children = append(children, n.Primary)
+	}
+	return Info{
+		ID:        nodeID("Unary", n.Pos.Offset, n),
+		Kind:      "Unary",
+		Offset:    n.Pos.Offset,
+		Traceable: true,
+		Children:  children,
+	}, nil
+}
+
+func (structureVisitor) VisitPrimary(n *ast.Primary) (Info, error) {
+	id := nodeID("Primary", n.Pos.Offset, n)
+	switch {
+	case n.Ident != nil:
+		return Info{ID: id, Kind: "Primary", Offset: n.Pos.Offset, Traceable: true, Children: []interface{}{
+			&Leaf{ID: fmt.Sprintf("Ident%p", n.Ident), Kind: "Ident", Payload: *n.Ident},
+		}}, nil
+	case n.Number != nil:
+		return Info{ID: id, Kind: "Primary", Offset: n.Pos.Offset, Traceable: true, Children: []interface{}{
+			&Leaf{ID: fmt.Sprintf("Number%p", n.Number), Kind: "Number", Payload: *n.Number},
+		}}, nil
+	case n.String != nil:
+		return Info{ID: id, Kind: "Primary", Offset: n.Pos.Offset, Traceable: true, Children: []interface{}{
+			&Leaf{ID: fmt.Sprintf("String%p", n.String), Kind: "String", Payload: *n.String},
+		}}, nil
+	case n.SubExpression != nil:
+		return Info{ID: id, Kind: "Primary", Offset: n.Pos.Offset, Traceable: true, Children: []interface{}{n.SubExpression}}, nil
+	default:
+		return Info{}, fmt.Errorf("astwalk: empty ast.Primary")
+	}
+}
+
+func (structureVisitor) VisitLeaf(n *Leaf) (Info, error) {
+	return Info{ID: n.ID, Kind: n.Kind, Payload: n.Payload}, nil
+}
+
+func opLeaf(op *string) *Leaf {
+	return &Leaf{ID: fmt.Sprintf("Op%p", op), Kind: "Op", Payload: *op}
+}
+
+// nodeID returns a graph node id for a container node. Pos.Offset alone
+// isn't enough to make it unique: it's the zero value whenever a node's
+// Pos was never set, which is true of nearly every hand-built *ast.Rule
+// in this package's tests, so two distinct nodes of the same kind would
+// otherwise collide onto the same id. Folding in the node's own pointer
+// keeps ids unique regardless of whether Pos was populated.
+func nodeID(kind string, offset int, n interface{}) string {
+	return fmt.Sprintf("%s%d_%p", kind, offset, n)
+}
+
+// Visit dispatches n to the Visitor method for its concrete type.
+func Visit(v Visitor, n interface{}) (Info, error) {
+	switch n := n.(type) {
+	case *ast.Rule:
+		return v.VisitRule(n)
+	case *ast.Expression:
+		return v.VisitExpression(n)
+	case *ast.BooleanExpression:
+		return v.VisitBooleanExpression(n)
+	case *ast.Comparison:
+		return v.VisitComparison(n)
+	case *ast.ArrayComparison:
+		return v.VisitArrayComparison(n)
+	case *ast.ScalarComparison:
+		return v.VisitScalarComparison(n)
+	case *ast.Array:
+		return v.VisitArray(n)
+	case *ast.BitOperation:
+		return v.VisitBitOperation(n)
+	case *ast.Unary:
+		return v.VisitUnary(n)
+	case *ast.Primary:
+		return v.VisitPrimary(n)
+	case *Leaf:
+		return v.VisitLeaf(n)
+	default:
+		return Info{}, fmt.Errorf("astwalk: unsupported node type: %s", reflect.TypeOf(n))
+	}
+}
+
+// Walk walks n in pre-order, calling fn with every node's Info before
+// recursing into the nodes it reports as children.
+func Walk(v Visitor, n interface{}, fn func(n interface{}, info Info) error) error {
+	info, err := Visit(v, n)
+	if err != nil {
+		return err
+	}
+	if err := fn(n, info); err != nil {
+		return err
+	}
+	for _, child := range info.Children {
+		if err := Walk(v, child, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+package astwalk
+
+import (
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/security/secl/ast"
+	"github.com/DataDog/datadog-agent/pkg/security/secl/ast/asttest"
+)
+
+func TestVisitDispatchesByConcreteType(t *testing.T) {
+	rule := asttest.IdentEqualsString()
+
+	info, err := Visit(Default, rule)
+	if err != nil {
+		t.Fatalf("Visit(rule): %v", err)
+	}
+	if info.Kind != "Rule" {
+		t.Fatalf("got kind %q, want Rule", info.Kind)
+	}
+
+	info, err = Visit(Default, rule.BooleanExpression.Expression.Comparison.BitOperation.Unary.Primary)
+	if err != nil {
+		t.Fatalf("Visit(primary): %v", err)
+	}
+	if info.Kind != "Primary" {
+		t.Fatalf("got kind %q, want Primary", info.Kind)
+	}
+	if len(info.Children) != 1 {
+		t.Fatalf("got %d children, want 1", len(info.Children))
+	}
+
+	leafInfo, err := Visit(Default, info.Children[0])
+	if err != nil {
+		t.Fatalf("Visit(leaf): %v", err)
+	}
+	if leafInfo.Kind != "Ident" || leafInfo.Payload != "a" {
+		t.Fatalf("got %+v, want Ident leaf with payload \"a\"", leafInfo)
+	}
+}
+
+// TestWalkVisitsEveryNodeOnce checks that Walk reaches every node in the
+// sample rule, including the synthetic Op/Ident/String leaves, without
+// any format needing to know how to recurse itself.
+func TestWalkVisitsEveryNodeOnce(t *testing.T) {
+	rule := asttest.IdentEqualsString()
+
+	var kinds []string
+	err := Walk(Default, rule, func(n interface{}, info Info) error {
+		kinds = append(kinds, info.Kind)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+
+	want := []string{
+		"Rule", "BooleanExpression", "Expression", "Comparison",
+		"BitOperation", "Unary", "Primary", "Ident",
+		"ScalarComparison", "Op", "BitOperation", "Unary", "Primary", "String",
+	}
+	if len(kinds) != len(want) {
+		t.Fatalf("got %d nodes %v, want %d nodes %v", len(kinds), kinds, len(want), want)
+	}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Fatalf("node %d: got kind %q, want %q (full: %v)", i, kinds[i], want[i], kinds)
+		}
+	}
+}
+
+// renamingVisitor wraps Default but reports a different Kind for
+// Primary nodes. It exists only to prove Visit/Walk dispatch to
+// whatever Visitor they're given, rather than being hardwired to
+// structureVisitor specifically.
+type renamingVisitor struct{ Visitor }
+
+func (renamingVisitor) VisitPrimary(n *ast.Primary) (Info, error) {
+	info, err := Default.VisitPrimary(n)
+	info.Kind = "CustomPrimary"
+	return info, err
+}
+
+// TestVisitorIsPluggable documents the actual payoff of this package:
+// dot.Marshaler, mermaid.Marshaler and astjson.Marshaler never switch on
+// concrete ast.* types themselves, only on the Info a Visitor reports.
+// Swapping in a different Visitor changes what every one of them
+// renders without any of them needing a code change. (Adding a new
+// ast.* node *kind* is a separate, slightly bigger change: it needs both
+// a new Visitor method and a new case in Visit's own type switch.)
+func TestVisitorIsPluggable(t *testing.T) {
+	rule := asttest.IdentEqualsString()
+	primary := rule.BooleanExpression.Expression.Comparison.BitOperation.Unary.Primary
+
+	info, err := Visit(renamingVisitor{Default}, primary)
+	if err != nil {
+		t.Fatalf("Visit: %v", err)
+	}
+	if info.Kind != "CustomPrimary" {
+		t.Fatalf("got kind %q, want CustomPrimary - Visit should dispatch to the given Visitor", info.Kind)
+	}
+
+	var sawCustomKind bool
+	err = Walk(renamingVisitor{Default}, rule, func(n interface{}, info Info) error {
+		if info.Kind == "CustomPrimary" {
+			sawCustomKind = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	if !sawCustomKind {
+		t.Fatalf("Walk never saw the CustomPrimary kind from the swapped-in Visitor")
+	}
+}
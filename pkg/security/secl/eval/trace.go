@@ -0,0 +1,70 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+package eval
+
+// Result is the outcome recorded for one AST node during a traced
+// evaluation.
+type Result int
+
+const (
+	// Unknown means the node was never reached during evaluation.
+	Unknown Result = iota
+	// True means the node evaluated to true.
+	True
+	// False means the node evaluated to false.
+	False
+	// ShortCircuited means the node was skipped because an enclosing
+	// &&/|| already determined the outcome of the expression it
+	// belongs to.
+	ShortCircuited
+)
+
+// NodeTrace is what the evaluator recorded for a single AST node,
+// identified by its source offset: whether it was reached and what it
+// evaluated to, and, for comparison nodes, the concrete left/right
+// operand values that were compared.
+type NodeTrace struct {
+	Result Result
+	Left   interface{}
+	Right  interface{}
+}
+
+// Trace records, per AST offset, what happened when a rule was evaluated
+// against a given event. It is filled in through Record and
+// RecordComparison; the evaluator does not call them itself. It lets tools such as pkg/security/secl/ast/dot turn a purely
+// structural rule diagram into a debugging aid for rule authors trying
+// to understand why a rule did or did not match.
+type Trace struct {
+	// Nodes maps an ast.* node's Pos.Offset to what happened when that
+	// node was evaluated.
+	Nodes map[int]NodeTrace
+}
+
+// NewTrace returns an empty Trace ready to be filled in with Record and
+// RecordComparison.
+func NewTrace() *Trace {
+	return &Trace{Nodes: make(map[int]NodeTrace)}
+}
+
+// Record stores the result of evaluating the node at offset.
+func (t *Trace) Record(offset int, result Result) {
+	t.Nodes[offset] = NodeTrace{Result: result}
+}
+
+// RecordComparison stores the result of evaluating a comparison node at
+// offset, along with the concrete values that were compared.
+func (t *Trace) RecordComparison(offset int, result Result, left, right interface{}) {
+	t.Nodes[offset] = NodeTrace{Result: result, Left: left, Right: right}
+}
+
+// Lookup returns what the trace recorded for offset, if anything.
+func (t *Trace) Lookup(offset int) (NodeTrace, bool) {
+	if t == nil {
+		return NodeTrace{}, false
+	}
+	nt, ok := t.Nodes[offset]
+	return nt, ok
+}
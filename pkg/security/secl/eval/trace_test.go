@@ -0,0 +1,35 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+package eval
+
+import "testing"
+
+func TestTraceRecordAndLookup(t *testing.T) {
+	trace := NewTrace()
+	trace.Record(10, True)
+	trace.RecordComparison(20, False, "/bin/sh", "/usr/bin/sudo")
+
+	nt, ok := trace.Lookup(10)
+	if !ok || nt.Result != True {
+		t.Fatalf("got %+v, %v, want True recorded at offset 10", nt, ok)
+	}
+
+	nt, ok = trace.Lookup(20)
+	if !ok || nt.Result != False || nt.Left != "/bin/sh" || nt.Right != "/usr/bin/sudo" {
+		t.Fatalf("got %+v, %v, want False with operands recorded at offset 20", nt, ok)
+	}
+
+	if _, ok := trace.Lookup(30); ok {
+		t.Fatalf("lookup of an unrecorded offset should miss")
+	}
+}
+
+func TestNilTraceLookupMisses(t *testing.T) {
+	var trace *Trace
+	if _, ok := trace.Lookup(10); ok {
+		t.Fatalf("lookup on a nil trace should miss, not panic")
+	}
+}
@@ -0,0 +1,182 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+package secl
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/DataDog/datadog-agent/pkg/security/secl/ast"
+)
+
+// PrinterOption configures a Printer.
+type PrinterOption func(*Printer)
+
+// WithSpacesAroundOperators controls whether operators are printed as
+// `a == b` (the default, spaces=true) or `a==b`.
+func WithSpacesAroundOperators(spaces bool) PrinterOption {
+	return func(p *Printer) { p.spaces = spaces }
+}
+
+// WithLineWrap wraps the printed rule at cols characters, breaking only
+// after a top-level `&&` or `||` operator; an operand longer than cols
+// stays whole on a line that exceeds cols. 0, the default, disables
+// wrapping.
+func WithLineWrap(cols int) PrinterOption {
+	return func(p *Printer) { p.wrapAt = cols }
+}
+
+// Printer walks an *ast.Rule and emits canonical SECL source text for
+// it, so tools that round-trip rules through pkg/security/secl/ast/dot
+// (or any other astwalk-based representation) can format-on-save
+// without lexical string munging. The only parenthesization policy
+// implemented today is "as parsed": a SubExpression is printed with
+// parens whenever the AST has one, since the AST doesn't carry operator
+// precedence beyond what SubExpression already encodes.
+type Printer struct {
+	spaces bool
+	wrapAt int
+}
+
+// NewPrinter returns a new Printer with spaces around operators and no
+// line wrapping, unless overridden by opts.
+func NewPrinter(opts ...PrinterOption) *Printer {
+	p := &Printer{spaces: true}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Print renders r as canonical SECL source text.
+func (p *Printer) Print(r *ast.Rule) string {
+	if p.wrapAt > 0 {
+		return wrap(p.expressionSegments(r.BooleanExpression.Expression), p.wrapAt)
+	}
+	return p.printBooleanExpression(r.BooleanExpression)
+}
+
+func (p *Printer) printBooleanExpression(n *ast.BooleanExpression) string {
+	return p.printExpression(n.Expression)
+}
+
+func (p *Printer) printExpression(n *ast.Expression) string {
+	s := p.printComparison(n.Comparison)
+	if n.Op != nil && n.Next != nil {
+		s += p.opSep(*n.Op) + p.printExpression(n.Next)
+	}
+	return s
+}
+
+func (p *Printer) printComparison(n *ast.Comparison) string {
+	s := p.printBitOperation(n.BitOperation)
+	if n.ArrayComparison != nil {
+		s += p.opSep(*n.ArrayComparison.Op) + p.printArray(n.ArrayComparison.Array)
+	}
+	if n.ScalarComparison != nil {
+		s += p.opSep(*n.ScalarComparison.Op) + p.printBitOperation(n.ScalarComparison.Next)
+	}
+	return s
+}
+
+func (p *Printer) printArray(n *ast.Array) string {
+	if len(n.Strings) > 0 {
+		quoted := make([]string, len(n.Strings))
+		for i, s := range n.Strings {
+			quoted[i] = strconv.Quote(s)
+		}
+		return "[" + strings.Join(quoted, ", ") + "]"
+	}
+	parts := make([]string, len(n.Numbers))
+	for i, v := range n.Numbers {
+		parts[i] = strconv.Itoa(v)
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+func (p *Printer) printBitOperation(n *ast.BitOperation) string {
+	s := p.printUnary(n.Unary)
+	if n.Op != nil && n.Next != nil {
+		s += p.opSep(*n.Op) + p.printBitOperation(n.Next)
+	}
+	return s
+}
+
+func (p *Printer) printUnary(n *ast.Unary) string {
+	if n.Op != nil && n.Unary != nil {
+		return *n.Op + p.printUnary(n.Unary)
+	}
+	if n.Primary != nil {
+		return p.printPrimary(n.Primary)
+	}
+	return ""
+}
+
+func (p *Printer) printPrimary(n *ast.Primary) string {
+	switch {
+	case n.Ident != nil:
+		return *n.Ident
+	case n.Number != nil:
+		return strconv.Itoa(*n.Number)
+	case n.String != nil:
+		return strconv.Quote(*n.String)
+	case n.SubExpression != nil:
+		return "(" + p.printExpression(n.SubExpression) + ")"
+	default:
+		return ""
+	}
+}
+
+func (p *Printer) opSep(op string) string {
+	if p.spaces {
+		return " " + op + " "
+	}
+	return op
+}
+
+// expressionSegments renders n's top-level &&/|| chain as one string per
+// operand, each one (but the last) ending in its trailing operator, so
+// wrap can break between them without ever re-scanning rendered text:
+// scanning text for "&&"/"||" can't tell a logical operator from the
+// same characters sitting inside a quoted string literal, but walking
+// the chain itself never has that ambiguity.
+func (p *Printer) expressionSegments(n *ast.Expression) []string {
+	seg := strings.TrimSpace(p.printComparison(n.Comparison))
+	if n.Op != nil && n.Next != nil {
+		seg += p.opSep(*n.Op)
+		return append([]string{strings.TrimSpace(seg)}, p.expressionSegments(n.Next)...)
+	}
+	return []string{seg}
+}
+
+// wrap greedily joins segments onto multiple lines, starting a new line
+// whenever the next segment would push the current one past cols. It only
+// breaks between segments, so a segment longer than cols exceeds it.
+func wrap(segments []string, cols int) string {
+	if len(segments) <= 1 {
+		if len(segments) == 1 {
+			return segments[0]
+		}
+		return ""
+	}
+
+	var b strings.Builder
+	lineLen := 0
+	for i, seg := range segments {
+		if i > 0 {
+			if lineLen+1+len(seg) > cols {
+				b.WriteString("\n")
+				lineLen = 0
+			} else {
+				b.WriteString(" ")
+				lineLen++
+			}
+		}
+		b.WriteString(seg)
+		lineLen += len(seg)
+	}
+	return b.String()
+}
@@ -0,0 +1,125 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+package secl
+
+import (
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/security/secl/ast"
+)
+
+func sudoPathRule() *ast.Rule {
+	ident := "process.file.path"
+	op := "=="
+	path := "/usr/bin/sudo"
+
+	return &ast.Rule{
+		BooleanExpression: &ast.BooleanExpression{
+			Expression: &ast.Expression{
+				Comparison: &ast.Comparison{
+					BitOperation: &ast.BitOperation{
+						Unary: &ast.Unary{
+							Primary: &ast.Primary{Ident: &ident},
+						},
+					},
+					ScalarComparison: &ast.ScalarComparison{
+						Op: &op,
+						Next: &ast.BitOperation{
+							Unary: &ast.Unary{
+								Primary: &ast.Primary{String: &path},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestPrintDefaultsToSpacesAroundOperators(t *testing.T) {
+	got := NewPrinter().Print(sudoPathRule())
+	want := `process.file.path == "/usr/bin/sudo"`
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestPrintWithoutSpaces(t *testing.T) {
+	got := NewPrinter(WithSpacesAroundOperators(false)).Print(sudoPathRule())
+	want := `process.file.path=="/usr/bin/sudo"`
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestPrintWrapsLongExpressions(t *testing.T) {
+	ident1, ident2 := "process.file.path", "container.id"
+	op1, op2, andOp := "==", "!=", "&&"
+	path := "/usr/bin/sudo"
+	empty := ""
+
+	rule := &ast.Rule{
+		BooleanExpression: &ast.BooleanExpression{
+			Expression: &ast.Expression{
+				Comparison: &ast.Comparison{
+					BitOperation: &ast.BitOperation{
+						Unary: &ast.Unary{Primary: &ast.Primary{Ident: &ident1}},
+					},
+					ScalarComparison: &ast.ScalarComparison{
+						Op:   &op1,
+						Next: &ast.BitOperation{Unary: &ast.Unary{Primary: &ast.Primary{String: &path}}},
+					},
+				},
+				Op: &andOp,
+				Next: &ast.Expression{
+					Comparison: &ast.Comparison{
+						BitOperation: &ast.BitOperation{
+							Unary: &ast.Unary{Primary: &ast.Primary{Ident: &ident2}},
+						},
+						ScalarComparison: &ast.ScalarComparison{
+							Op:   &op2,
+							Next: &ast.BitOperation{Unary: &ast.Unary{Primary: &ast.Primary{String: &empty}}},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	got := NewPrinter(WithLineWrap(20)).Print(rule)
+	want := "process.file.path == \"/usr/bin/sudo\" &&\ncontainer.id != \"\""
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestPrintWrapNeverSplitsInsideAStringLiteral(t *testing.T) {
+	ident := "process.args"
+	op := "=="
+	args := "echo a && echo b"
+
+	rule := &ast.Rule{
+		BooleanExpression: &ast.BooleanExpression{
+			Expression: &ast.Expression{
+				Comparison: &ast.Comparison{
+					BitOperation: &ast.BitOperation{
+						Unary: &ast.Unary{Primary: &ast.Primary{Ident: &ident}},
+					},
+					ScalarComparison: &ast.ScalarComparison{
+						Op:   &op,
+						Next: &ast.BitOperation{Unary: &ast.Unary{Primary: &ast.Primary{String: &args}}},
+					},
+				},
+			},
+		},
+	}
+
+	got := NewPrinter(WithLineWrap(20)).Print(rule)
+	want := `process.args == "echo a && echo b"`
+	if got != want {
+		t.Fatalf("got %q, want %q (the literal's own \"&&\" must never be treated as a wrap point)", got, want)
+	}
+}